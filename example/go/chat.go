@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/firebase/genkit/go/plugins/googlegenai"
+)
+
+// runChatCommand parses the flags for the "chat" subcommand and starts a
+// session, e.g. `story chat -character "a fox" -setting "a train station"`
+// or `story chat -resume session.json`.
+func runChatCommand(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("chat", flag.ExitOnError)
+	character := fs.String("character", "a friendly robot", "main character for the story")
+	setting := fs.String("setting", "a magical library", "setting where the story takes place")
+	sessionPath := fs.String("session", "story-session.json", "path to persist the chat session to")
+	resumePath := fs.String("resume", "", "path to a previously saved session to resume")
+	if err := fs.Parse(args); err != nil {
+		log.Fatalf("parsing chat flags: %v", err)
+	}
+
+	apiKey := os.Getenv("GEMINI_API_KEY")
+	if apiKey == "" {
+		log.Fatal("GEMINI_API_KEY environment variable is required")
+	}
+
+	g := genkit.Init(ctx,
+		genkit.WithPlugins(&googlegenai.GoogleAI{}),
+		genkit.WithDefaultModel("googleai/gemini-2.5-flash"),
+	)
+	generator := NewStoryGenerator(g)
+
+	input := StoryInput{Character: *character, Setting: *setting}
+	runChat(ctx, generator, input, *sessionPath, *resumePath)
+}
+
+// maxHistoryTokens bounds how much chat history gets sent with each turn.
+// When a session grows past this, the oldest turns are dropped so the
+// request doesn't blow the model's context window.
+const maxHistoryTokens = 8000
+
+// chatSession is the on-disk representation of an in-progress chat, so a
+// session can be resumed with --resume later.
+type chatSession struct {
+	History []*ai.Message `json:"history"`
+	Story   *Story        `json:"story"`
+}
+
+// loadChatSession reads a session previously saved by (*chatSession).save.
+func loadChatSession(path string) (*chatSession, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var session chatSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("parsing session file %s: %w", path, err)
+	}
+	return &session, nil
+}
+
+// save writes the session to path as indented JSON so it can be resumed.
+func (s *chatSession) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// runChat drives the interactive "chat" subcommand: generate an initial
+// Story, then repeatedly take a refinement message from stdin and re-emit
+// an updated Story, persisting the growing message history after each turn.
+func runChat(ctx context.Context, generator *StoryGenerator, input StoryInput, sessionPath string, resumePath string) {
+	var session *chatSession
+
+	if resumePath != "" {
+		loaded, err := loadChatSession(resumePath)
+		if err != nil {
+			log.Fatalf("Error resuming session: %v", err)
+		}
+		session = loaded
+		fmt.Printf("Resumed session from %s\n", resumePath)
+	} else {
+		story, err := generator.Generate(ctx, input)
+		if err != nil {
+			log.Fatalf("Error generating story: %v", err)
+		}
+		session = &chatSession{
+			History: []*ai.Message{
+				ai.NewUserMessage(ai.NewTextPart(fmt.Sprintf("Write a story about %s in %s.", input.Character, input.Setting))),
+				ai.NewModelMessage(ai.NewTextPart(mustJSON(story))),
+			},
+			Story: story,
+		}
+	}
+
+	printStory(session.Story)
+	if err := session.save(sessionPath); err != nil {
+		log.Printf("Warning: could not save session: %v", err)
+	}
+
+	fmt.Printf("\nEnter refinements (e.g. \"make it scarier\"), or Ctrl-D to stop. Session saved to %s.\n", sessionPath)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			break
+		}
+		message := scanner.Text()
+		if message == "" {
+			continue
+		}
+
+		session.History = append(session.History, ai.NewUserMessage(ai.NewTextPart(message)))
+		session.History = trimHistory(session.History)
+
+		story, err := generator.generate(ctx, session.History)
+		if err != nil {
+			log.Printf("Error refining story: %v", err)
+			continue
+		}
+
+		session.History = append(session.History, ai.NewModelMessage(ai.NewTextPart(mustJSON(story))))
+		session.Story = story
+		printStory(story)
+
+		if err := session.save(sessionPath); err != nil {
+			log.Printf("Warning: could not save session: %v", err)
+		}
+	}
+}
+
+// estimatedTokens approximates how many tokens history would cost, since
+// Genkit doesn't expose a tokenizer independent of an actual generate call.
+// ~4 characters per token is the same rule of thumb Gemini's own docs use
+// for English text; it only needs to be in the right ballpark to keep a
+// chat session from blowing the context window.
+func estimatedTokens(history []*ai.Message) int {
+	chars := 0
+	for _, msg := range history {
+		for _, part := range msg.Content {
+			chars += len(part.Text)
+		}
+	}
+	return chars / 4
+}
+
+// trimHistory drops the oldest turns from history until it fits within
+// maxHistoryTokens, per estimatedTokens.
+func trimHistory(history []*ai.Message) []*ai.Message {
+	for len(history) > 3 && estimatedTokens(history) > maxHistoryTokens {
+		// Drop the oldest user/model turn, keeping the most recent exchange.
+		history = history[2:]
+	}
+	return history
+}
+
+func printStory(story *Story) {
+	fmt.Println("\n=== Story ===")
+	storyJSON, _ := json.MarshalIndent(story, "", "  ")
+	fmt.Println(string(storyJSON))
+}
+
+func mustJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,115 @@
+// Package streamjson incrementally parses a single streamed JSON object,
+// emitting each top-level field as soon as its value has fully arrived. It
+// exists because Gemini streams structured output token-by-token, and a
+// caller wants to show the Title the moment it's done rather than waiting
+// for the whole object to close.
+package streamjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// FieldFunc is called once per top-level field, in the order it appears in
+// the JSON object, with the field already decoded into a Go value (string,
+// float64, []any, map[string]any, etc., per the encoding/json conventions).
+type FieldFunc func(name string, value any)
+
+// Decoder accepts partial byte slices of a single top-level JSON object via
+// Write, in any chunking, and reports completed fields to a FieldFunc as
+// they close. Call Close once all input has been written to learn whether
+// the object was well-formed.
+type Decoder struct {
+	pr      *io.PipeReader
+	pw      *io.PipeWriter
+	dec     *json.Decoder
+	onField FieldFunc
+	done    chan error
+}
+
+// NewDecoder returns a Decoder that reports completed fields to onField.
+func NewDecoder(onField FieldFunc) *Decoder {
+	pr, pw := io.Pipe()
+	d := &Decoder{
+		pr:      pr,
+		pw:      pw,
+		dec:     json.NewDecoder(pr),
+		onField: onField,
+		done:    make(chan error, 1),
+	}
+	go d.run()
+	return d
+}
+
+// Write feeds the next chunk of the stream. Chunk size is arbitrary; a
+// single byte at a time works just as well as the whole object at once.
+func (d *Decoder) Write(p []byte) (int, error) {
+	return d.pw.Write(p)
+}
+
+// Close signals that no more input is coming and waits for the parse to
+// finish, returning any error encountered (including a malformed or
+// truncated object).
+func (d *Decoder) Close() error {
+	if err := d.pw.Close(); err != nil {
+		return err
+	}
+	return <-d.done
+}
+
+func (d *Decoder) run() {
+	defer close(d.done)
+
+	// fail closes the read side of the pipe with err before reporting it, so
+	// a Write racing with (or arriving after) this goroutine's exit fails
+	// fast with err instead of blocking forever on the unbuffered pipe.
+	fail := func(err error) {
+		d.pr.CloseWithError(err)
+		d.done <- err
+	}
+
+	tok, err := d.dec.Token()
+	if err != nil {
+		fail(err)
+		return
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		fail(fmt.Errorf("streamjson: expected top-level object, got %v", tok))
+		return
+	}
+
+	for d.dec.More() {
+		keyTok, err := d.dec.Token()
+		if err != nil {
+			fail(err)
+			return
+		}
+		key, ok := keyTok.(string)
+		if !ok {
+			fail(fmt.Errorf("streamjson: expected object key, got %v", keyTok))
+			return
+		}
+
+		var raw json.RawMessage
+		if err := d.dec.Decode(&raw); err != nil {
+			fail(err)
+			return
+		}
+		var value any
+		if err := json.Unmarshal(raw, &value); err != nil {
+			fail(err)
+			return
+		}
+		if d.onField != nil {
+			d.onField(key, value)
+		}
+	}
+
+	if _, err := d.dec.Token(); err != nil {
+		fail(err)
+		return
+	}
+	d.pr.Close()
+	d.done <- nil
+}
@@ -0,0 +1,89 @@
+package streamjson
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// field is a recorded (name, value) callback invocation.
+type field struct {
+	name  string
+	value any
+}
+
+// decodeInChunks feeds transcript to a fresh Decoder chunkSize bytes at a
+// time and returns the fields it reported, in order.
+func decodeInChunks(t *testing.T, transcript string, chunkSize int) ([]field, error) {
+	t.Helper()
+
+	var got []field
+	dec := NewDecoder(func(name string, value any) {
+		got = append(got, field{name, value})
+	})
+
+	for i := 0; i < len(transcript); i += chunkSize {
+		end := i + chunkSize
+		if end > len(transcript) {
+			end = len(transcript)
+		}
+		if _, err := dec.Write([]byte(transcript[i:end])); err != nil {
+			return got, err
+		}
+	}
+
+	return got, dec.Close()
+}
+
+func TestDecoder_ChunkSizesAgree(t *testing.T) {
+	const transcript = `{"title":"The Fox","characters":["Fox","Owl"],"mood":"happy"}`
+
+	want := []field{
+		{"title", "The Fox"},
+		{"characters", []any{"Fox", "Owl"}},
+		{"mood", "happy"},
+	}
+
+	for _, chunkSize := range []int{1, 16, 4096} {
+		got, err := decodeInChunks(t, transcript, chunkSize)
+		if err != nil {
+			t.Fatalf("chunk size %d: Close: %v", chunkSize, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("chunk size %d: got fields %+v, want %+v", chunkSize, got, want)
+		}
+	}
+}
+
+func TestDecoder_MalformedObjectFailsClose(t *testing.T) {
+	_, err := decodeInChunks(t, `{"title": "unterminated`, 1)
+	if err == nil {
+		t.Fatal("Close: want error for truncated object, got nil")
+	}
+}
+
+func TestDecoder_WriteAfterErrorDoesNotHang(t *testing.T) {
+	dec := NewDecoder(nil)
+
+	// Not a top-level object: run() exits on the first token.
+	if _, err := dec.Write([]byte(`"just a string"`)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := dec.Close(); err == nil {
+		t.Fatal("Close: want error for non-object top level, got nil")
+	}
+
+	// Before the pipe-reader fix, this Write would block forever because
+	// run() had already exited without draining or closing the pipe.
+	done := make(chan struct{})
+	go func() {
+		dec.Write([]byte("more data"))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Write after Close did not return: decoder deadlocked")
+	}
+}
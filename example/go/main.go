@@ -2,14 +2,25 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/api"
 	"github.com/firebase/genkit/go/genkit"
 	"github.com/firebase/genkit/go/plugins/googlegenai"
+	"google.golang.org/genai"
+
+	"github.com/unstacked-labs/genkit-bwai-workshops/example/go/internal/streamjson"
+	"github.com/unstacked-labs/genkit-bwai-workshops/example/go/providers"
+	"github.com/unstacked-labs/genkit-bwai-workshops/example/go/tools"
 )
 
 // Story represents a generated story with structured data
@@ -21,48 +32,567 @@ type Story struct {
 	Mood       string   `json:"mood" jsonschema:"description=The overall mood (happy, adventurous, mysterious, etc.)"`
 }
 
+// Media is an optional image or audio blob used to ground a story in
+// something the user provides, rather than text alone.
+type Media struct {
+	// Path or URL pointing at the media. A plain http(s) URL is passed to
+	// Gemini as-is; anything else is read from the local filesystem and
+	// inlined as a Blob.
+	Source   string
+	MIMEType string
+}
+
 // StoryInput represents the input parameters for story generation
 type StoryInput struct {
 	Character string `json:"character" jsonschema:"description=Main character for the story"`
 	Setting   string `json:"setting" jsonschema:"description=Setting where the story takes place"`
+
+	// Image and Audio are optional inspiration media. When set, they are
+	// attached to the prompt as additional Gemini content parts so the
+	// generated Characters/Setting can be grounded in them.
+	Image *Media
+	Audio *Media
+}
+
+// SafetySetting mirrors one entry of Gemini's safetySettings[] request
+// field: a harm category and the threshold at which it should be blocked,
+// e.g. {Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_ONLY_HIGH"}.
+type SafetySetting struct {
+	Category  string
+	Threshold string
+}
+
+// StoryOptions configures aspects of generation beyond the prompt itself.
+type StoryOptions struct {
+	// SafetySettings overrides Gemini's default safety thresholds. Leave nil
+	// to use the model's defaults.
+	SafetySettings []SafetySetting
+}
+
+// SafetyBlockedError is returned when Gemini blocks a story instead of
+// completing it, naming the safety category responsible so a caller can
+// retry with a softened prompt or a lower threshold for that category.
+type SafetyBlockedError struct {
+	Category string
+	Reason   string
+}
+
+func (e *SafetyBlockedError) Error() string {
+	return fmt.Sprintf("story blocked by safety filter (category=%s): %s", e.Category, e.Reason)
+}
+
+// StoryGenerator generates structured Story data from a StoryInput using a
+// Genkit instance. It exists so callers (the CLI here, or a future server)
+// can share the same prompt-building and multipart-assembly logic.
+type StoryGenerator struct {
+	g *genkit.Genkit
+
+	tools    map[string]ai.Tool
+	toolMode ai.ToolChoice
+
+	pool    *providers.Pool
+	options StoryOptions
+}
+
+// NewStoryGenerator returns a StoryGenerator backed by g.
+func NewStoryGenerator(g *genkit.Genkit) *StoryGenerator {
+	return &StoryGenerator{g: g}
+}
+
+// EnableTools lets the generator call out to real-world tools (place
+// lookups, animal facts, weather) while writing a story. mode controls
+// whether the model may ignore the tools ("auto") or must use at least one
+// before answering ("required").
+func (s *StoryGenerator) EnableTools(available []ai.Tool, mode string) {
+	s.tools = make(map[string]ai.Tool, len(available))
+	for _, t := range available {
+		s.tools[t.Name()] = t
+	}
+	switch mode {
+	case "required":
+		s.toolMode = ai.ToolChoiceRequired
+	default:
+		s.toolMode = ai.ToolChoiceAuto
+	}
+}
+
+// UsePool switches generation from the single default model to the given
+// provider fallback chain: on failure, the next provider in pool is tried
+// automatically instead of the call simply failing.
+func (s *StoryGenerator) UsePool(pool *providers.Pool) {
+	s.pool = pool
+}
+
+// SetOptions configures safety settings (and any future StoryOptions) used
+// by every subsequent Generate/StreamGenerate call.
+func (s *StoryGenerator) SetOptions(opts StoryOptions) {
+	s.options = opts
+}
+
+// toolRefs returns the enabled tools as ai.ToolRef values for ai.WithTools.
+func (s *StoryGenerator) toolRefs() []ai.ToolRef {
+	refs := make([]ai.ToolRef, 0, len(s.tools))
+	for _, t := range s.tools {
+		refs = append(refs, t)
+	}
+	return refs
+}
+
+// Generate produces a Story for input, attaching any image or audio media as
+// additional prompt parts. With no media it falls back to a plain text
+// prompt, matching the original text-only behavior.
+func (s *StoryGenerator) Generate(ctx context.Context, input StoryInput) (*Story, error) {
+	history, err := s.buildHistory(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return s.generate(ctx, history)
+}
+
+// StreamGenerate behaves like Generate, but calls onField as soon as each
+// top-level Story field (Title, then Characters, then Plot, ...) has
+// streamed in, rather than waiting for the whole response. The returned
+// Story is the same final, fully-validated value Generate would return.
+func (s *StoryGenerator) StreamGenerate(ctx context.Context, input StoryInput, onField streamjson.FieldFunc) (*Story, error) {
+	history, err := s.buildHistory(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := streamjson.NewDecoder(onField)
+
+	streamOpt := ai.WithStreaming(func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+		// A decoder Write error is ignored for the same reason as the Close
+		// error below: it must not abort the generation itself.
+		_, _ = dec.Write([]byte(chunk.Text()))
+		return nil
+	})
+
+	story, err := s.generate(ctx, history, streamOpt)
+
+	// The incremental decoder is best-effort progress output; GenerateData's
+	// own parse of the complete response is still the source of truth for
+	// the returned Story, so a streamjson error here doesn't fail the call.
+	_ = dec.Close()
+
+	if err != nil {
+		return nil, err
+	}
+	return story, nil
+}
+
+// configOpt builds the ai.WithConfig option for a generate call against pc,
+// merging pc's own temperature/max-tokens/safety settings with the
+// generator-level SafetySettings from SetOptions. It returns nil when
+// neither has anything to configure, so callers don't send an empty config
+// that could mask the model's own defaults.
+func (s *StoryGenerator) configOpt(pc providers.ProviderConfig) ai.GenerateOption {
+	cfg := &genai.GenerateContentConfig{}
+	configured := false
+
+	if pc.Temperature != 0 {
+		temperature := float32(pc.Temperature)
+		cfg.Temperature = &temperature
+		configured = true
+	}
+	if pc.MaxTokens != 0 {
+		cfg.MaxOutputTokens = int32(pc.MaxTokens)
+		configured = true
+	}
+	for _, setting := range pc.SafetySettings {
+		cfg.SafetySettings = append(cfg.SafetySettings, &genai.SafetySetting{
+			Category:  genai.HarmCategory(setting.Category),
+			Threshold: genai.HarmBlockThreshold(setting.Threshold),
+		})
+		configured = true
+	}
+	for _, setting := range s.options.SafetySettings {
+		cfg.SafetySettings = append(cfg.SafetySettings, &genai.SafetySetting{
+			Category:  genai.HarmCategory(setting.Category),
+			Threshold: genai.HarmBlockThreshold(setting.Threshold),
+		})
+		configured = true
+	}
+
+	if !configured {
+		return nil
+	}
+	return ai.WithConfig(cfg)
+}
+
+// generate runs the structured-output call for history, applying extraOpts
+// (used by StreamGenerate to add its streaming callback). With no provider
+// pool configured it uses the default model registered at Init; with one
+// configured, it tries each provider in order via pool.Try, falling back to
+// the next on failure.
+func (s *StoryGenerator) generate(ctx context.Context, history []*ai.Message, extraOpts ...ai.GenerateOption) (*Story, error) {
+	if s.pool == nil {
+		opts := []ai.GenerateOption{ai.WithMessages(history...)}
+		if opt := s.configOpt(providers.ProviderConfig{}); opt != nil {
+			opts = append(opts, opt)
+		}
+		opts = append(opts, extraOpts...)
+
+		story, resp, err := genkit.GenerateData[Story](ctx, s.g, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if blockErr := blockedErr(resp); blockErr != nil {
+			return nil, blockErr
+		}
+		return story, nil
+	}
+
+	var story *Story
+	err := s.pool.Try(func(pc providers.ProviderConfig) (int, error) {
+		opts := []ai.GenerateOption{ai.WithMessages(history...), ai.WithModelName(pc.Model)}
+		if opt := s.configOpt(pc); opt != nil {
+			opts = append(opts, opt)
+		}
+		opts = append(opts, extraOpts...)
+
+		result, resp, err := genkit.GenerateData[Story](ctx, s.g, opts...)
+		if err != nil {
+			return 0, err
+		}
+		if blockErr := blockedErr(resp); blockErr != nil {
+			return 0, blockErr
+		}
+		story = result
+
+		tokens := 0
+		if resp != nil && resp.Usage != nil {
+			tokens = resp.Usage.TotalTokens
+		}
+		return tokens, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return story, nil
+}
+
+// blockedErr inspects resp's finish reason and safety ratings, returning a
+// *SafetyBlockedError naming the offending category when the story was
+// blocked instead of completed. It returns nil for a normal response. Safety
+// ratings aren't part of genkit's plugin-agnostic ai.ModelResponse, so this
+// digs into the googlegenai plugin's raw candidates, stashed under
+// resp.Custom["candidates"].
+func blockedErr(resp *ai.ModelResponse) error {
+	if resp == nil || resp.FinishReason != ai.FinishReasonBlocked {
+		return nil
+	}
+
+	category := "unknown"
+	custom, _ := resp.Custom.(map[string]any)
+	if candidates, ok := custom["candidates"].([]*genai.Candidate); ok {
+		for _, candidate := range candidates {
+			for _, rating := range candidate.SafetyRatings {
+				if rating.Blocked {
+					category = string(rating.Category)
+					break
+				}
+			}
+		}
+	}
+
+	return &SafetyBlockedError{Category: category, Reason: resp.FinishMessage}
+}
+
+// buildHistory assembles the message history for input: a user message
+// carrying the prompt plus any image/audio parts, extended with a completed
+// tool-calling exchange when tools are enabled.
+func (s *StoryGenerator) buildHistory(ctx context.Context, input StoryInput) ([]*ai.Message, error) {
+	prompt := fmt.Sprintf(`Create a short, fun story about %s in %s.
+		Make it light-hearted and suitable for all ages.
+		Return the response as structured data with title, characters, setting, plot, and mood.`,
+		input.Character, input.Setting)
+
+	parts := []*ai.Part{ai.NewTextPart(prompt)}
+
+	if input.Image != nil {
+		mediaParts, err := mediaPart(input.Image, "Use the attached image as inspiration for the characters and setting.")
+		if err != nil {
+			return nil, fmt.Errorf("loading image: %w", err)
+		}
+		parts = append(parts, mediaParts...)
+	}
+
+	if input.Audio != nil {
+		mediaParts, err := mediaPart(input.Audio, "Use the attached audio as inspiration for the mood and plot.")
+		if err != nil {
+			return nil, fmt.Errorf("loading audio: %w", err)
+		}
+		parts = append(parts, mediaParts...)
+	}
+
+	history := []*ai.Message{ai.NewUserMessage(parts...)}
+
+	if len(s.tools) > 0 {
+		final, err := s.runToolLoop(ctx, history)
+		if err != nil {
+			return nil, err
+		}
+		history = final
+	}
+
+	return history, nil
+}
+
+// runToolLoop sends history to the model and, as long as it comes back
+// asking to call a tool, executes that tool and feeds the result back in,
+// mirroring the tools[]/toolConfig request-response cycle until the model
+// stops requesting tools. It returns the extended message history so the
+// caller can make one final structured-output call.
+func (s *StoryGenerator) runToolLoop(ctx context.Context, history []*ai.Message) ([]*ai.Message, error) {
+	const maxTurns = 5
+
+	// toolMode is only forced for the first turn: once the model has made at
+	// least one tool call, forcing it forever would mean it can never come
+	// back with a plain answer, so the loop would always run to maxTurns.
+	// After the first turn we relax to "auto".
+	toolMode := s.toolMode
+
+	for turn := 0; turn < maxTurns; turn++ {
+		// WithReturnToolRequests(true) is what makes this a hand-rolled loop
+		// at all: without it, Genkit would execute requested tools itself
+		// and only ever hand back a final, tool-free response.
+		resp, err := s.generateRaw(ctx,
+			ai.WithMessages(history...),
+			ai.WithTools(s.toolRefs()...),
+			ai.WithToolChoice(toolMode),
+			ai.WithReturnToolRequests(true),
+		)
+		if err != nil {
+			return nil, err
+		}
+		toolMode = ai.ToolChoiceAuto
+
+		history = append(history, resp.Message)
+
+		requests := resp.ToolRequests()
+		if len(requests) == 0 {
+			return history, nil
+		}
+
+		responses := make([]*ai.Part, 0, len(requests))
+		for _, req := range requests {
+			name := req.ToolRequest.Name
+			tool, ok := s.tools[name]
+			if !ok {
+				return nil, fmt.Errorf("model requested unknown tool %q", name)
+			}
+			output, err := tool.RunRaw(ctx, req.ToolRequest.Input)
+			if err != nil {
+				return nil, fmt.Errorf("running tool %s: %w", name, err)
+			}
+			responses = append(responses, ai.NewResponseForToolRequest(req, output))
+		}
+		history = append(history, ai.NewMessage(ai.RoleTool, nil, responses...))
+	}
+
+	return history, fmt.Errorf("story generation: tool loop did not terminate after %d turns", maxTurns)
+}
+
+// generateRaw runs a plain (non-structured) generation call, falling back
+// across s.pool's providers the same way generate does for structured
+// output. It's used by runToolLoop, which needs the raw ai.ModelResponse to
+// inspect tool requests rather than a parsed Story.
+func (s *StoryGenerator) generateRaw(ctx context.Context, opts ...ai.GenerateOption) (*ai.ModelResponse, error) {
+	if s.pool == nil {
+		allOpts := opts
+		if opt := s.configOpt(providers.ProviderConfig{}); opt != nil {
+			allOpts = append([]ai.GenerateOption{opt}, opts...)
+		}
+		return genkit.Generate(ctx, s.g, allOpts...)
+	}
+
+	var resp *ai.ModelResponse
+	err := s.pool.Try(func(pc providers.ProviderConfig) (int, error) {
+		allOpts := []ai.GenerateOption{ai.WithModelName(pc.Model)}
+		if opt := s.configOpt(pc); opt != nil {
+			allOpts = append(allOpts, opt)
+		}
+		allOpts = append(allOpts, opts...)
+
+		result, err := genkit.Generate(ctx, s.g, allOpts...)
+		if err != nil {
+			return 0, err
+		}
+		resp = result
+
+		tokens := 0
+		if result.Usage != nil {
+			tokens = result.Usage.TotalTokens
+		}
+		return tokens, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// mediaPart turns a Media reference into Gemini content parts: the media
+// part, followed by an instruction part so the model knows why the media is
+// attached. A plain http(s) Source is passed by URL; anything else is read
+// from disk and inlined as a Blob.
+func mediaPart(m *Media, instruction string) ([]*ai.Part, error) {
+	if strings.HasPrefix(m.Source, "http://") || strings.HasPrefix(m.Source, "https://") {
+		return []*ai.Part{ai.NewMediaPart(m.MIMEType, m.Source), ai.NewTextPart(instruction)}, nil
+	}
+
+	data, err := os.ReadFile(m.Source)
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	return []*ai.Part{ai.NewMediaPart(m.MIMEType, "data:"+m.MIMEType+";base64,"+encoded), ai.NewTextPart(instruction)}, nil
+}
+
+// guessMIMEType infers a MIME type from source's file extension, since the
+// CLI takes no explicit MIME type; unknown extensions get
+// application/octet-stream.
+func guessMIMEType(source string) string {
+	ext := strings.ToLower(filepath.Ext(source))
+	switch ext {
+	case ".png":
+		return "image/png"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".webp":
+		return "image/webp"
+	case ".mp3":
+		return "audio/mpeg"
+	case ".wav":
+		return "audio/wav"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// resolvePlugins returns the Genkit plugins to register for cfg: Google AI
+// always (the default model and the "googleai/..." provider entries need
+// it), plus Vertex AI when cfg's provider chain names it. It fails on any
+// other plugin name instead of registering only Google AI and letting
+// Pool.Try silently fall through a "model not found" error for a provider
+// whose plugin was never registered.
+func resolvePlugins(cfg *providers.Config) ([]api.Plugin, error) {
+	plugins := []api.Plugin{&googlegenai.GoogleAI{}}
+	if cfg == nil {
+		return plugins, nil
+	}
+
+	for _, name := range cfg.PluginNames() {
+		switch name {
+		case "googleai":
+			// Already registered above.
+		case "vertexai":
+			plugins = append(plugins, &googlegenai.VertexAI{})
+		default:
+			return nil, fmt.Errorf("provider config names unknown plugin %q (supported: googleai, vertexai)", name)
+		}
+	}
+	return plugins, nil
 }
 
 func main() {
 	ctx := context.Background()
 
+	if len(os.Args) > 1 && os.Args[1] == "chat" {
+		runChatCommand(ctx, os.Args[2:])
+		return
+	}
+
+	character := flag.String("character", "a friendly robot", "main character for the story")
+	setting := flag.String("setting", "a magical library", "setting where the story takes place")
+	imagePath := flag.String("image", "", "optional path or URL to an image used as story inspiration")
+	audioPath := flag.String("audio", "", "optional path or URL to an audio clip used as story inspiration")
+	toolsEnabled := flag.Bool("tools", false, "let the model call out to lookupPlace/lookupAnimalFacts/getWeather tools")
+	toolMode := flag.String("tool-mode", "auto", "tool choice when -tools is set: \"auto\" or \"required\"")
+	providersPath := flag.String("providers", "", "path to a YAML/JSON provider fallback chain (see providers.Config); falls back to the default model when unset")
+	safetyThreshold := flag.String("safety-threshold", "", "if set, overrides Gemini's safety threshold (e.g. BLOCK_ONLY_HIGH) for the harassment, hate speech, sexually explicit and dangerous content categories")
+	flag.Parse()
+
 	// Load environment variables
 	apiKey := os.Getenv("GEMINI_API_KEY")
 	if apiKey == "" {
 		log.Fatal("GEMINI_API_KEY environment variable is required")
 	}
 
-	// Initialize Genkit with Google AI plugin
+	var cfg *providers.Config
+	if *providersPath != "" {
+		loaded, err := providers.LoadConfig(*providersPath)
+		if err != nil {
+			log.Fatalf("Error loading provider config: %v", err)
+		}
+		cfg = loaded
+	}
+
+	plugins, err := resolvePlugins(cfg)
+	if err != nil {
+		log.Fatalf("Error resolving provider plugins: %v", err)
+	}
+
+	// Initialize Genkit with whichever plugins the provider chain needs.
 	g := genkit.Init(ctx,
-		genkit.WithPlugins(&googlegenai.GoogleAI{}),
+		genkit.WithPlugins(plugins...),
 		genkit.WithDefaultModel("googleai/gemini-2.5-flash"),
 	)
 
-	// Generate a structured story
-	fmt.Println("=== Generating Structured Story ===")
+	generator := NewStoryGenerator(g)
+	if *toolsEnabled {
+		generator.EnableTools(tools.DefineAll(g), *toolMode)
+	}
+
+	var pool *providers.Pool
+	if cfg != nil {
+		pool = providers.NewPool(cfg)
+		generator.UsePool(pool)
+	}
+
+	if *safetyThreshold != "" {
+		generator.SetOptions(StoryOptions{
+			SafetySettings: []SafetySetting{
+				{Category: string(genai.HarmCategoryHarassment), Threshold: *safetyThreshold},
+				{Category: string(genai.HarmCategoryHateSpeech), Threshold: *safetyThreshold},
+				{Category: string(genai.HarmCategorySexuallyExplicit), Threshold: *safetyThreshold},
+				{Category: string(genai.HarmCategoryDangerousContent), Threshold: *safetyThreshold},
+			},
+		})
+	}
 
 	input := StoryInput{
-		Character: "a friendly robot",
-		Setting:   "a magical library",
+		Character: *character,
+		Setting:   *setting,
+	}
+	if *imagePath != "" {
+		input.Image = &Media{Source: *imagePath, MIMEType: guessMIMEType(*imagePath)}
+	}
+	if *audioPath != "" {
+		input.Audio = &Media{Source: *audioPath, MIMEType: guessMIMEType(*audioPath)}
 	}
 
+	// Generate a structured story
+	fmt.Println("=== Generating Structured Story ===")
 	fmt.Printf("Character: %s\n", input.Character)
 	fmt.Printf("Setting: %s\n", input.Setting)
+	if input.Image != nil {
+		fmt.Printf("Image: %s\n", input.Image.Source)
+	}
+	if input.Audio != nil {
+		fmt.Printf("Audio: %s\n", input.Audio.Source)
+	}
 
-	prompt := fmt.Sprintf(`Create a short, fun story about %s in %s. 
-		Make it light-hearted and suitable for all ages.
-		Return the response as structured data with title, characters, setting, plot, and mood.`,
-		input.Character, input.Setting)
-
-	// Generate structured data using Go structs
-	story, _, err := genkit.GenerateData[Story](ctx, g,
-		ai.WithPrompt(prompt),
-	)
+	fmt.Println("\n=== Streaming Story ===")
+	story, err := generator.StreamGenerate(ctx, input, func(name string, value any) {
+		fmt.Printf("  [%s] %v\n", name, value)
+	})
 	if err != nil {
+		var blocked *SafetyBlockedError
+		if errors.As(err, &blocked) {
+			log.Fatalf("Story blocked by safety filter (category=%s): %s", blocked.Category, blocked.Reason)
+		}
 		log.Fatalf("Error generating story: %v", err)
 	}
 
@@ -71,7 +601,7 @@ func main() {
 	storyJSON, _ := json.MarshalIndent(story, "", "  ")
 	fmt.Println(string(storyJSON))
 
-	// Generate different stories
+	// Generate different stories (text-only, demonstrating the fallback path)
 	fmt.Println("\n=== Generating Different Stories ===")
 
 	stories := []StoryInput{
@@ -83,14 +613,7 @@ func main() {
 	for i, storyInput := range stories {
 		fmt.Printf("\n--- Story %d: %s in %s ---\n", i+1, storyInput.Character, storyInput.Setting)
 
-		prompt := fmt.Sprintf(`Create a short, fun story about %s in %s. 
-			Make it light-hearted and suitable for all ages.
-			Return the response as structured data with title, characters, setting, plot, and mood.`,
-			storyInput.Character, storyInput.Setting)
-
-		story, _, err := genkit.GenerateData[Story](ctx, g,
-			ai.WithPrompt(prompt),
-		)
+		story, err := generator.Generate(ctx, storyInput)
 		if err != nil {
 			log.Printf("Error generating story %d: %v", i+1, err)
 			continue
@@ -100,4 +623,12 @@ func main() {
 		fmt.Printf("Plot: %s\n", story.Plot)
 		fmt.Printf("Mood: %s\n", story.Mood)
 	}
+
+	if pool != nil {
+		fmt.Println("\n=== Provider Stats ===")
+		for model, stats := range pool.Stats() {
+			fmt.Printf("%s: attempts=%d successes=%d failures=%d totalLatency=%s totalTokens=%d\n",
+				model, stats.Attempts, stats.Successes, stats.Failures, stats.TotalLatency, stats.TotalTokens)
+		}
+	}
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"google.golang.org/genai"
+)
+
+// echoInput is the input to the fake "echo" tool stubbed in
+// TestRunToolLoop_TerminatesAndValidates.
+type echoInput struct {
+	Text string `json:"text"`
+}
+
+// TestRunToolLoop_TerminatesAndValidates stubs a tool and a fake model that
+// requests it once before answering, then asserts the tool loop terminates
+// (rather than running to maxTurns) and that the final response still
+// validates against the Story schema.
+func TestRunToolLoop_TerminatesAndValidates(t *testing.T) {
+	ctx := context.Background()
+	g := genkit.Init(ctx, genkit.WithDefaultModel("test/fake-story-model"))
+
+	echoTool := genkit.DefineTool(g, "echo", "Echoes back its input",
+		func(ctx *ai.ToolContext, input echoInput) (string, error) {
+			return "echo: " + input.Text, nil
+		})
+
+	turns := 0
+	genkit.DefineModel(g, "test/fake-story-model",
+		&ai.ModelOptions{Supports: &ai.ModelSupports{Tools: true, ToolChoice: true, Multiturn: true}},
+		func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			turns++
+			if turns == 1 {
+				return &ai.ModelResponse{
+					FinishReason: ai.FinishReasonStop,
+					Message: ai.NewMessage(ai.RoleModel, nil,
+						ai.NewToolRequestPart(&ai.ToolRequest{Name: "echo", Input: map[string]any{"text": "hello"}, Ref: "1"})),
+				}, nil
+			}
+
+			story := Story{
+				Title:      "The Echoing Fox",
+				Characters: []string{"a clever fox"},
+				Setting:    "a quiet forest",
+				Plot:       "the fox hears its own echo and learns a lesson",
+				Mood:       "happy",
+			}
+			b, err := json.Marshal(story)
+			if err != nil {
+				return nil, err
+			}
+			return &ai.ModelResponse{
+				FinishReason: ai.FinishReasonStop,
+				Message:      ai.NewModelTextMessage(string(b)),
+			}, nil
+		})
+
+	generator := NewStoryGenerator(g)
+	generator.EnableTools([]ai.Tool{echoTool}, "required")
+
+	story, err := generator.Generate(ctx, StoryInput{Character: "a clever fox", Setting: "a quiet forest"})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if turns < 2 {
+		t.Fatalf("expected the tool loop to take at least 2 model turns (tool call, then final answer), got %d", turns)
+	}
+	if story.Title == "" || story.Plot == "" {
+		t.Fatalf("Generate: returned Story failed to validate: %+v", story)
+	}
+}
+
+// TestRunToolLoop_RequiredModeTerminates confirms that "-tool-mode required"
+// doesn't force every turn to call a tool: once the model has made one call,
+// the loop must relax to auto so a plain final answer can end it. Without
+// that, the loop always ran to maxTurns and returned an error.
+func TestRunToolLoop_RequiredModeTerminates(t *testing.T) {
+	ctx := context.Background()
+	g := genkit.Init(ctx, genkit.WithDefaultModel("test/fake-required-model"))
+
+	noopTool := genkit.DefineTool(g, "noop", "Does nothing",
+		func(ctx *ai.ToolContext, input struct{}) (string, error) {
+			return "ok", nil
+		})
+
+	turns := 0
+	genkit.DefineModel(g, "test/fake-required-model",
+		&ai.ModelOptions{Supports: &ai.ModelSupports{Tools: true, ToolChoice: true, Multiturn: true}},
+		func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			turns++
+			// Like a real model, keep calling a tool for as long as the
+			// request forces one.
+			if turns == 1 || req.ToolChoice == ai.ToolChoiceRequired {
+				return &ai.ModelResponse{
+					FinishReason: ai.FinishReasonStop,
+					Message: ai.NewMessage(ai.RoleModel, nil,
+						ai.NewToolRequestPart(&ai.ToolRequest{Name: "noop", Input: map[string]any{}, Ref: "1"})),
+				}, nil
+			}
+			story := Story{Title: "Done", Characters: []string{"x"}, Setting: "y", Plot: "z", Mood: "happy"}
+			b, _ := json.Marshal(story)
+			return &ai.ModelResponse{FinishReason: ai.FinishReasonStop, Message: ai.NewModelTextMessage(string(b))}, nil
+		})
+
+	generator := NewStoryGenerator(g)
+	generator.EnableTools([]ai.Tool{noopTool}, "required")
+
+	if _, err := generator.Generate(ctx, StoryInput{Character: "x", Setting: "y"}); err != nil {
+		t.Fatalf("Generate: %v (tool loop likely ran to maxTurns without relaxing tool choice)", err)
+	}
+}
+
+// TestGenerate_SafetyBlockedSurfacesError stubs a model that reports its
+// response as blocked, with a googlegenai-shaped candidate identifying which
+// safety category tripped. It confirms Generate returns a *SafetyBlockedError
+// naming that category rather than an empty Story and a generic error.
+func TestGenerate_SafetyBlockedSurfacesError(t *testing.T) {
+	ctx := context.Background()
+	g := genkit.Init(ctx, genkit.WithDefaultModel("test/fake-blocked-model"))
+
+	genkit.DefineModel(g, "test/fake-blocked-model", &ai.ModelOptions{},
+		func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			return &ai.ModelResponse{
+				FinishReason:  ai.FinishReasonBlocked,
+				FinishMessage: "blocked for harassment",
+				Message:       ai.NewModelTextMessage(""),
+				Custom: map[string]any{
+					"candidates": []*genai.Candidate{
+						{
+							SafetyRatings: []*genai.SafetyRating{
+								{Blocked: true, Category: genai.HarmCategoryHarassment},
+							},
+						},
+					},
+				},
+			}, nil
+		})
+
+	generator := NewStoryGenerator(g)
+
+	story, err := generator.Generate(ctx, StoryInput{Character: "a clever fox", Setting: "a quiet forest"})
+	if err == nil {
+		t.Fatalf("Generate: want error for a blocked response, got story %+v", story)
+	}
+
+	var blocked *SafetyBlockedError
+	if !errors.As(err, &blocked) {
+		t.Fatalf("Generate: want a *SafetyBlockedError, got %v (%T)", err, err)
+	}
+	if blocked.Category != string(genai.HarmCategoryHarassment) {
+		t.Errorf("SafetyBlockedError.Category = %q, want %q", blocked.Category, genai.HarmCategoryHarassment)
+	}
+}
@@ -0,0 +1,189 @@
+// Package providers lets a generation call fall back across an ordered list
+// of model backends (e.g. Vertex AI, then Google AI, then a smaller model)
+// instead of hard-coding a single plugin and model in main.go.
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SafetySetting mirrors Gemini's safetySettings[] entries (category +
+// threshold pairs) in a config-file-friendly shape.
+type SafetySetting struct {
+	Category  string `json:"category" yaml:"category"`
+	Threshold string `json:"threshold" yaml:"threshold"`
+}
+
+// ProviderConfig describes one entry in the fallback chain.
+type ProviderConfig struct {
+	// Plugin is the Genkit plugin ID the model belongs to, e.g. "googleai"
+	// or "vertexai". The plugin must already be registered with
+	// genkit.WithPlugins at Init time; this package only selects among
+	// models the caller has already wired up.
+	Plugin string `json:"plugin" yaml:"plugin"`
+	// Model is the plugin-qualified model name Genkit expects, e.g.
+	// "googleai/gemini-2.5-flash".
+	Model string `json:"model" yaml:"model"`
+	// APIKeyEnv names the environment variable holding this provider's API
+	// key, checked before the provider is attempted.
+	APIKeyEnv string `json:"apiKeyEnv" yaml:"apiKeyEnv"`
+
+	Temperature    float64         `json:"temperature,omitempty" yaml:"temperature,omitempty"`
+	MaxTokens      int             `json:"maxTokens,omitempty" yaml:"maxTokens,omitempty"`
+	SafetySettings []SafetySetting `json:"safetySettings,omitempty" yaml:"safetySettings,omitempty"`
+}
+
+// Config is the top-level provider fallback chain, in the order they should
+// be tried.
+type Config struct {
+	Providers []ProviderConfig `json:"providers" yaml:"providers"`
+}
+
+// LoadConfig reads a provider chain from a YAML or JSON file, chosen by its
+// extension (.yaml/.yml vs anything else treated as JSON).
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading provider config %s: %w", path, err)
+	}
+
+	var cfg Config
+	if ext := strings.ToLower(path); strings.HasSuffix(ext, ".yaml") || strings.HasSuffix(ext, ".yml") {
+		err = yaml.Unmarshal(data, &cfg)
+	} else {
+		err = json.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing provider config %s: %w", path, err)
+	}
+	if len(cfg.Providers) == 0 {
+		return nil, fmt.Errorf("provider config %s lists no providers", path)
+	}
+	return &cfg, nil
+}
+
+// PluginNames returns the distinct plugin names referenced by c.Providers,
+// in the order they first appear. A caller uses this to register exactly
+// the Genkit plugins a config needs (e.g. Vertex AI, when a provider entry
+// names it) instead of only ever registering a hard-coded default, which
+// would let a configured provider silently fail as "model not found".
+func (c *Config) PluginNames() []string {
+	seen := make(map[string]bool, len(c.Providers))
+	var names []string
+	for _, pc := range c.Providers {
+		if !seen[pc.Plugin] {
+			seen[pc.Plugin] = true
+			names = append(names, pc.Plugin)
+		}
+	}
+	return names
+}
+
+// Stats tracks how a single provider in the chain has performed.
+type Stats struct {
+	Attempts     int
+	Successes    int
+	Failures     int
+	TotalLatency time.Duration
+	TotalTokens  int
+}
+
+// Pool tries each configured provider in order, falling back to the next on
+// failure (rate-limit, safety block, 5xx, or any other generation error).
+type Pool struct {
+	providers []ProviderConfig
+
+	mu    sync.Mutex
+	stats map[string]*Stats
+}
+
+// NewPool returns a Pool that tries cfg.Providers in order.
+func NewPool(cfg *Config) *Pool {
+	p := &Pool{
+		providers: cfg.Providers,
+		stats:     make(map[string]*Stats, len(cfg.Providers)),
+	}
+	for _, pc := range cfg.Providers {
+		p.stats[pc.Model] = &Stats{}
+	}
+	return p
+}
+
+// Attempt is called once per provider in the chain, in order, with that
+// provider's full config (model name, temperature, max tokens, safety
+// settings, ...). It should perform one generation call against that
+// provider and report tokens used, if known, so Stats can track them.
+// Attempt (not Try) owns turning ProviderConfig's fields into generate
+// options, since that depends on the plugin-specific config type (e.g.
+// Gemini's genai.GenerateContentConfig) that this package doesn't know
+// about.
+type Attempt func(pc ProviderConfig) (tokensUsed int, err error)
+
+// Try runs attempt against each provider in the chain until one succeeds,
+// skipping any provider whose APIKeyEnv isn't set. It returns the error from
+// the last provider tried if every provider failed.
+func (p *Pool) Try(attempt Attempt) error {
+	var lastErr error
+	tried := false
+
+	for _, pc := range p.providers {
+		if pc.APIKeyEnv != "" && os.Getenv(pc.APIKeyEnv) == "" {
+			continue
+		}
+		tried = true
+
+		start := time.Now()
+		tokens, err := attempt(pc)
+		latency := time.Since(start)
+
+		p.record(pc.Model, latency, tokens, err)
+
+		if err == nil {
+			return nil
+		}
+		lastErr = fmt.Errorf("provider %s: %w", pc.Model, err)
+	}
+
+	if !tried {
+		return fmt.Errorf("no provider in the chain had its API key set")
+	}
+	return lastErr
+}
+
+func (p *Pool) record(model string, latency time.Duration, tokens int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.stats[model]
+	if !ok {
+		s = &Stats{}
+		p.stats[model] = s
+	}
+	s.Attempts++
+	s.TotalLatency += latency
+	s.TotalTokens += tokens
+	if err == nil {
+		s.Successes++
+	} else {
+		s.Failures++
+	}
+}
+
+// Stats returns a snapshot of per-provider usage, keyed by model name.
+func (p *Pool) Stats() map[string]Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out := make(map[string]Stats, len(p.stats))
+	for model, s := range p.stats {
+		out[model] = *s
+	}
+	return out
+}
@@ -0,0 +1,118 @@
+package providers
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestPool(providers ...ProviderConfig) *Pool {
+	return NewPool(&Config{Providers: providers})
+}
+
+func TestPool_TryFallsBackOnError(t *testing.T) {
+	pool := newTestPool(
+		ProviderConfig{Model: "flaky/model"},
+		ProviderConfig{Model: "reliable/model"},
+	)
+
+	var tried []string
+	err := pool.Try(func(pc ProviderConfig) (int, error) {
+		tried = append(tried, pc.Model)
+		if pc.Model == "flaky/model" {
+			return 0, errors.New("rate limited")
+		}
+		return 42, nil
+	})
+	if err != nil {
+		t.Fatalf("Try: %v", err)
+	}
+	if want := []string{"flaky/model", "reliable/model"}; !reflect.DeepEqual(tried, want) {
+		t.Fatalf("tried providers = %v, want %v", tried, want)
+	}
+
+	stats := pool.Stats()
+	if s := stats["flaky/model"]; s.Attempts != 1 || s.Successes != 0 || s.Failures != 1 {
+		t.Errorf("flaky/model stats = %+v, want 1 attempt, 0 successes, 1 failure", s)
+	}
+	if s := stats["reliable/model"]; s.Attempts != 1 || s.Successes != 1 || s.Failures != 0 || s.TotalTokens != 42 {
+		t.Errorf("reliable/model stats = %+v, want 1 attempt, 1 success, 0 failures, 42 tokens", s)
+	}
+}
+
+func TestPool_TryReturnsLastErrorWhenAllFail(t *testing.T) {
+	pool := newTestPool(
+		ProviderConfig{Model: "a/model"},
+		ProviderConfig{Model: "b/model"},
+	)
+
+	err := pool.Try(func(pc ProviderConfig) (int, error) {
+		return 0, errors.New(pc.Model + " failed")
+	})
+	if err == nil {
+		t.Fatal("Try: want an error when every provider fails, got nil")
+	}
+	if got := err.Error(); !strings.Contains(got, "b/model") {
+		t.Errorf("Try error = %q, want it to name the last provider tried (b/model)", got)
+	}
+
+	stats := pool.Stats()
+	if stats["a/model"].Failures != 1 || stats["b/model"].Failures != 1 {
+		t.Errorf("stats = %+v, want both providers recorded as failed", stats)
+	}
+}
+
+func TestPool_TrySkipsProviderWithUnsetAPIKeyEnv(t *testing.T) {
+	const unsetEnv = "PROVIDERS_TEST_UNSET_API_KEY"
+	os.Unsetenv(unsetEnv)
+
+	pool := newTestPool(
+		ProviderConfig{Model: "needs-key/model", APIKeyEnv: unsetEnv},
+		ProviderConfig{Model: "no-key-needed/model"},
+	)
+
+	var tried []string
+	err := pool.Try(func(pc ProviderConfig) (int, error) {
+		tried = append(tried, pc.Model)
+		return 0, nil
+	})
+	if err != nil {
+		t.Fatalf("Try: %v", err)
+	}
+	if want := []string{"no-key-needed/model"}; !reflect.DeepEqual(tried, want) {
+		t.Fatalf("tried providers = %v, want %v (needs-key/model should be skipped)", tried, want)
+	}
+
+	if s, ok := pool.Stats()["needs-key/model"]; ok && s.Attempts != 0 {
+		t.Errorf("needs-key/model stats = %+v, want no attempts recorded", s)
+	}
+}
+
+func TestPool_TryFailsWhenEveryProviderIsSkipped(t *testing.T) {
+	const unsetEnv = "PROVIDERS_TEST_UNSET_API_KEY"
+	os.Unsetenv(unsetEnv)
+
+	pool := newTestPool(ProviderConfig{Model: "needs-key/model", APIKeyEnv: unsetEnv})
+
+	err := pool.Try(func(pc ProviderConfig) (int, error) {
+		t.Fatal("attempt should not run when every provider lacks its API key")
+		return 0, nil
+	})
+	if err == nil {
+		t.Fatal("Try: want an error when no provider has its API key set, got nil")
+	}
+}
+
+func TestConfig_PluginNames(t *testing.T) {
+	cfg := &Config{Providers: []ProviderConfig{
+		{Plugin: "googleai", Model: "googleai/gemini-2.5-flash"},
+		{Plugin: "vertexai", Model: "vertexai/gemini-2.5-pro"},
+		{Plugin: "googleai", Model: "googleai/gemini-2.5-flash-lite"},
+	}}
+
+	if got, want := cfg.PluginNames(), []string{"googleai", "vertexai"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PluginNames() = %v, want %v", got, want)
+	}
+}
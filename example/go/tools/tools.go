@@ -0,0 +1,92 @@
+// Package tools registers the Genkit tools the story generator can call out
+// to for details about places, animals and weather. The tool bodies are
+// placeholders: they return fixed or templated values built from their
+// input, not data looked up from a real source.
+package tools
+
+import (
+	"fmt"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// PlaceInput names a place to look up.
+type PlaceInput struct {
+	Name string `json:"name" jsonschema:"description=Name of the place to look up"`
+}
+
+// PlaceOutput describes a place well enough to ground a story's setting.
+type PlaceOutput struct {
+	Description string `json:"description" jsonschema:"description=A short description of the place"`
+	Country     string `json:"country" jsonschema:"description=The country the place is in"`
+}
+
+// AnimalInput names an animal to look up.
+type AnimalInput struct {
+	Species string `json:"species" jsonschema:"description=The animal species to look up"`
+}
+
+// AnimalOutput describes an animal well enough to ground a story's character.
+type AnimalOutput struct {
+	Facts []string `json:"facts" jsonschema:"description=A few real facts about the animal"`
+}
+
+// WeatherInput names a location to check the weather for.
+type WeatherInput struct {
+	Location string `json:"location" jsonschema:"description=The location to check the weather for"`
+}
+
+// WeatherOutput describes current conditions at a location.
+type WeatherOutput struct {
+	Conditions   string  `json:"conditions" jsonschema:"description=A short description of current conditions"`
+	TemperatureC float64 `json:"temperatureC" jsonschema:"description=Current temperature in Celsius"`
+}
+
+// DefineAll registers every tool in this package on g and returns them ready
+// to hand to a StoryGenerator.
+func DefineAll(g *genkit.Genkit) []ai.Tool {
+	return []ai.Tool{
+		DefineLookupPlace(g),
+		DefineLookupAnimalFacts(g),
+		DefineGetWeather(g),
+	}
+}
+
+// DefineLookupPlace registers the lookupPlace tool on g.
+func DefineLookupPlace(g *genkit.Genkit) ai.Tool {
+	return genkit.DefineTool(g, "lookupPlace", "Looks up a real place by name and returns a short description and its country",
+		func(ctx *ai.ToolContext, input PlaceInput) (PlaceOutput, error) {
+			// Placeholder lookup: a real implementation would call a
+			// geocoding or encyclopedia API.
+			return PlaceOutput{
+				Description: fmt.Sprintf("%s is a real place worth visiting.", input.Name),
+				Country:     "Unknown",
+			}, nil
+		})
+}
+
+// DefineLookupAnimalFacts registers the lookupAnimalFacts tool on g. It
+// returns a single templated sentence naming the species.
+func DefineLookupAnimalFacts(g *genkit.Genkit) ai.Tool {
+	return genkit.DefineTool(g, "lookupAnimalFacts", "Looks up a few real facts about an animal species",
+		func(ctx *ai.ToolContext, input AnimalInput) (AnimalOutput, error) {
+			return AnimalOutput{
+				Facts: []string{
+					fmt.Sprintf("The %s is a real animal.", input.Species),
+				},
+			}, nil
+		})
+}
+
+// DefineGetWeather registers the getWeather tool on g. It reports clear skies
+// at 20°C for every location.
+func DefineGetWeather(g *genkit.Genkit) ai.Tool {
+	return genkit.DefineTool(g, "getWeather", "Gets the current weather conditions for a location",
+		func(ctx *ai.ToolContext, input WeatherInput) (WeatherOutput, error) {
+			return WeatherOutput{
+				Conditions:   "clear skies",
+				TemperatureC: 20,
+			}, nil
+		})
+}